@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// knownVideoCodecs maps an SDP rtpmap encoding name to the pion mime type
+// it corresponds to.
+var knownVideoCodecs = map[string]string{
+	"VP8":  webrtc.MimeTypeVP8,
+	"VP9":  webrtc.MimeTypeVP9,
+	"H264": webrtc.MimeTypeH264,
+	"AV1":  webrtc.MimeTypeAV1,
+}
+
+var knownAudioCodecs = map[string]string{
+	"opus": webrtc.MimeTypeOpus,
+}
+
+// offeredCodec is one codec a remote peer proposed in its offer, at the
+// payload type it proposed.
+type offeredCodec struct {
+	kind        webrtc.RTPCodecType
+	payloadType webrtc.PayloadType
+	capability  webrtc.RTPCodecCapability
+}
+
+// NegotiateOffer parses offer once, returning a MediaEngine that
+// registers whatever VP8/VP9/H264/AV1 video or Opus audio codecs the
+// remote peer proposed (using the payload types it proposed them with),
+// along with the capability of the first recognized video and audio
+// codec for creating the fan-out track that will relay this publisher's
+// media. This lets the server interoperate with whatever codec a
+// browser or encoder chose instead of assuming VP8.
+func NegotiateOffer(offer string) (m *webrtc.MediaEngine, video, audio webrtc.RTPCodecCapability, err error) {
+	codecs, err := parseOfferedCodecs(offer)
+	if err != nil {
+		return nil, webrtc.RTPCodecCapability{}, webrtc.RTPCodecCapability{}, err
+	}
+
+	m = &webrtc.MediaEngine{}
+	for _, codec := range codecs {
+		if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+			RTPCodecCapability: codec.capability,
+			PayloadType:        codec.payloadType,
+		}, codec.kind); err != nil {
+			return nil, webrtc.RTPCodecCapability{}, webrtc.RTPCodecCapability{}, fmt.Errorf("failed to register %s: %w", codec.capability.MimeType, err)
+		}
+
+		switch codec.kind {
+		case webrtc.RTPCodecTypeVideo:
+			if video.MimeType == "" {
+				video = codec.capability
+			}
+		case webrtc.RTPCodecTypeAudio:
+			if audio.MimeType == "" {
+				audio = codec.capability
+			}
+		}
+	}
+
+	if video.MimeType == "" {
+		video = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+	}
+	if audio.MimeType == "" {
+		audio = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}
+	}
+
+	return m, video, audio, nil
+}
+
+func parseOfferedCodecs(offer string) ([]offeredCodec, error) {
+	var parsed sdp.SessionDescription
+	if err := parsed.Unmarshal([]byte(offer)); err != nil {
+		return nil, fmt.Errorf("failed to parse offer: %w", err)
+	}
+
+	var codecs []offeredCodec
+	for _, media := range parsed.MediaDescriptions {
+		var kind webrtc.RTPCodecType
+		var known map[string]string
+		switch media.MediaName.Media {
+		case "video":
+			kind, known = webrtc.RTPCodecTypeVideo, knownVideoCodecs
+		case "audio":
+			kind, known = webrtc.RTPCodecTypeAudio, knownAudioCodecs
+		default:
+			continue
+		}
+
+		for _, format := range media.MediaName.Formats {
+			payloadType, err := strconv.Atoi(format)
+			if err != nil {
+				continue
+			}
+
+			name, clockRate, channels, ok := rtpMapFor(media, format)
+			if !ok {
+				continue
+			}
+
+			mimeType, ok := known[name]
+			if !ok {
+				continue
+			}
+
+			codecs = append(codecs, offeredCodec{
+				kind:        kind,
+				payloadType: webrtc.PayloadType(payloadType),
+				capability: webrtc.RTPCodecCapability{
+					MimeType:    mimeType,
+					ClockRate:   clockRate,
+					Channels:    channels,
+					SDPFmtpLine: fmtpFor(media, format),
+				},
+			})
+		}
+	}
+
+	return codecs, nil
+}
+
+// rtpMapFor finds the "a=rtpmap:<payloadType> <name>/<clockRate>[/<channels>]"
+// attribute for format within media.
+func rtpMapFor(media *sdp.MediaDescription, format string) (name string, clockRate uint32, channels uint16, ok bool) {
+	for _, attr := range media.Attributes {
+		if attr.Key != "rtpmap" {
+			continue
+		}
+
+		fields := strings.SplitN(attr.Value, " ", 2)
+		if len(fields) != 2 || fields[0] != format {
+			continue
+		}
+
+		parts := strings.Split(fields[1], "/")
+		if len(parts) < 2 {
+			continue
+		}
+
+		rate, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			continue
+		}
+
+		var ch uint64
+		if len(parts) == 3 {
+			ch, _ = strconv.ParseUint(parts[2], 10, 16)
+		}
+
+		return parts[0], uint32(rate), uint16(ch), true
+	}
+
+	return "", 0, 0, false
+}
+
+// fmtpFor finds the "a=fmtp:<payloadType> <params>" attribute for format
+// within media, if any.
+func fmtpFor(media *sdp.MediaDescription, format string) string {
+	for _, attr := range media.Attributes {
+		if attr.Key != "fmtp" {
+			continue
+		}
+
+		fields := strings.SplitN(attr.Value, " ", 2)
+		if len(fields) == 2 && fields[0] == format {
+			return fields[1]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,162 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/ivfreader"
+	"github.com/pion/webrtc/v4/pkg/media/oggreader"
+)
+
+const (
+	videoClockRate = 90000
+	audioClockRate = 48000
+)
+
+// PublishFiles publishes a VP8 .ivf file and an Opus .ogg file as a
+// synthetic publisher of streamID, pacing writes to match each sample's
+// duration so subscribers see realistic playback. It's useful for
+// smoke-testing the Cloudflare TURN relay path without a live camera or
+// microphone. The two files are streamed independently, and each one
+// loops by reopening and replaying from the start once it reaches EOF,
+// so a short clip still behaves like a standing source.
+func PublishFiles(registry *StreamRegistry, streamID, videoPath, audioPath string) error {
+	publisher, err := registry.Publish(streamID,
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: videoClockRate},
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: audioClockRate, Channels: 2})
+	if err != nil {
+		return fmt.Errorf("failed to publish %s: %w", streamID, err)
+	}
+
+	go streamIVF(publisher, videoPath)
+	go streamOgg(publisher, audioPath)
+
+	return nil
+}
+
+// streamIVF reads VP8 frames from an IVF file and writes them to
+// publisher as RTP packets, sleeping between frames to match the file's
+// frame rate. Once the file is exhausted it's reopened and replayed from
+// the start, looping forever so a short smoke-test clip still behaves
+// like a standing source.
+func streamIVF(publisher *Publisher, path string) {
+	packetizer := rtp.NewPacketizer(1200, 96, rand.Uint32(), &codecs.VP8Payloader{}, rtp.NewRandomSequencer(), videoClockRate)
+
+	for {
+		if !streamIVFOnce(publisher, path, packetizer) {
+			return
+		}
+	}
+}
+
+// streamIVFOnce streams path's frames once, start to finish. It reports
+// whether the caller should loop again: false means the file couldn't
+// be opened/parsed or publisher stopped accepting writes, either of
+// which will never get better on a retry.
+func streamIVFOnce(publisher *Publisher, path string, packetizer rtp.Packetizer) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("filestream: failed to open %s: %v\n", path, err)
+		return false
+	}
+	defer file.Close()
+
+	ivf, header, err := ivfreader.NewWith(file)
+	if err != nil {
+		fmt.Printf("filestream: failed to read IVF header from %s: %v\n", path, err)
+		return false
+	}
+
+	frameDuration := time.Duration(float32(header.TimebaseNumerator)/float32(header.TimebaseDenominator)*1000) * time.Millisecond
+
+	for {
+		frame, _, err := ivf.ParseNextFrame()
+		if errors.Is(err, io.EOF) {
+			return true
+		}
+		if err != nil {
+			fmt.Printf("filestream: failed to read frame from %s: %v\n", path, err)
+			return false
+		}
+
+		samples := uint32(frameDuration.Seconds() * videoClockRate)
+		for _, pkt := range packetizer.Packetize(frame, samples) {
+			if err := publisher.WriteVideo(pkt); err != nil {
+				return false
+			}
+		}
+
+		time.Sleep(frameDuration)
+	}
+}
+
+// streamOgg reads Opus pages from an Ogg file and writes them to
+// publisher as RTP packets, sleeping between pages to match the number
+// of samples each page's granule position reports. Once the file is
+// exhausted it's reopened and replayed from the start, looping forever
+// so a short smoke-test clip still behaves like a standing source.
+func streamOgg(publisher *Publisher, path string) {
+	packetizer := rtp.NewPacketizer(1200, 111, rand.Uint32(), &codecs.OpusPayloader{}, rtp.NewRandomSequencer(), audioClockRate)
+
+	for {
+		if !streamOggOnce(publisher, path, packetizer) {
+			return
+		}
+	}
+}
+
+// streamOggOnce streams path's pages once, start to finish. It reports
+// whether the caller should loop again: false means the file couldn't
+// be opened/parsed or publisher stopped accepting writes, either of
+// which will never get better on a retry.
+func streamOggOnce(publisher *Publisher, path string, packetizer rtp.Packetizer) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("filestream: failed to open %s: %v\n", path, err)
+		return false
+	}
+	defer file.Close()
+
+	ogg, _, err := oggreader.NewWith(file)
+	if err != nil {
+		fmt.Printf("filestream: failed to read Ogg header from %s: %v\n", path, err)
+		return false
+	}
+
+	var lastGranule uint64
+	for {
+		pageData, pageHeader, err := ogg.ParseNextPage()
+		if errors.Is(err, io.EOF) {
+			return true
+		}
+		if err != nil {
+			fmt.Printf("filestream: failed to read page from %s: %v\n", path, err)
+			return false
+		}
+
+		sampleCount := pageHeader.GranulePosition - lastGranule
+		lastGranule = pageHeader.GranulePosition
+		pageDuration := time.Duration(float64(sampleCount)/audioClockRate*1000) * time.Millisecond
+
+		for _, pkt := range packetizer.Packetize(pageData, uint32(sampleCount)) {
+			if err := publisher.WriteAudio(pkt); err != nil {
+				return false
+			}
+		}
+
+		time.Sleep(pageDuration)
+	}
+}
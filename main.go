@@ -7,20 +7,14 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
-	"strings"
-
-	"github.com/pion/interceptor"
-	"github.com/pion/interceptor/pkg/intervalpli"
-	"github.com/pion/webrtc/v4"
+	"time"
 )
 
 const cloudflareAPI = "https://rtc.live.cloudflare.com/v1"
@@ -32,201 +26,64 @@ var (
 )
 
 func main() {
-	turnCredentials, err := getTurnCredentials()
-	if err != nil {
-		panic(err)
-	}
-
-	// Create a MediaEngine object to configure the supported codec
-	m := &webrtc.MediaEngine{}
-
-	// Setup the codecs you want to use.
-	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
-		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000, Channels: 0},
-		PayloadType:        96,
-	}, webrtc.RTPCodecTypeVideo); err != nil {
-		panic(err)
-	}
-
-	// Create an InterceptorRegistry
-	i := &interceptor.Registry{}
-	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
-		panic(err)
-	}
-
-	// Register an intervalpli factory
-	intervalPliFactory, err := intervalpli.NewReceiverInterceptor()
-	if err != nil {
-		panic(err)
-	}
-	i.Add(intervalPliFactory)
-
-	// Create the API object with the MediaEngine
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i))
-
-	// Prepare the configuration with Cloudflare TURN servers
-	config := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs:       turnCredentials.ICECredentials.URLs,
-				Username:   turnCredentials.ICECredentials.Username,
-				Credential: turnCredentials.ICECredentials.Credential,
-			},
-		},
-	}
-
-	// Create a new RTCPeerConnection
-	peerConnection, err := api.NewPeerConnection(config)
-	if err != nil {
-		panic(err)
-	}
-	defer func() {
-		if cErr := peerConnection.Close(); cErr != nil {
-			fmt.Printf("cannot close peerConnection: %v\n", cErr)
-		}
-	}()
-
-	// Create Track that we send video back to the browser on
-	outputTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "pion")
-	if err != nil {
-		panic(err)
-	}
-
-	// Add this newly created track to the PeerConnection
-	rtpSender, err := peerConnection.AddTrack(outputTrack)
-	if err != nil {
-		panic(err)
-	}
-
-	// Read incoming RTCP packets
-	go func() {
-		rtcpBuf := make([]byte, 1500)
-		for {
-			if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
-				return
-			}
-		}
-	}()
-
-	// Wait for the offer to be pasted
-	offer := webrtc.SessionDescription{}
-	decode(readUntilNewline(), &offer)
-
-	// Set the remote SessionDescription
-	err = peerConnection.SetRemoteDescription(offer)
+	// The MediaEngine (and thus the codecs available) is negotiated per
+	// request from each offer instead of fixed here; see
+	// PopulateMediaEngineFromOffer. TURN credentials are shared across
+	// every PeerConnection and kept fresh by TurnCredentialManager.
+	turnManager, err := NewTurnCredentialManager(os.Getenv("CLOUDFLARE_CUSTOM_IDENTIFIER"), nil)
 	if err != nil {
 		panic(err)
 	}
 
-	// Set a handler for when a new remote track starts
-	peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-		fmt.Printf("Track has started, of type %d: %s \n", track.PayloadType(), track.Codec().MimeType)
-		for {
-			rtp, _, readErr := track.ReadRTP()
-			if readErr != nil {
-				panic(readErr)
-			}
+	srv := NewServer(turnManager)
 
-			if writeErr := outputTrack.WriteRTP(rtp); writeErr != nil {
-				panic(writeErr)
-			}
+	if videoPath, audioPath := os.Getenv("FILESTREAM_VIDEO"), os.Getenv("FILESTREAM_AUDIO"); videoPath != "" && audioPath != "" {
+		streamID := os.Getenv("FILESTREAM_ID")
+		if streamID == "" {
+			streamID = "filestream"
 		}
-	})
-
-	// Set the handler for Peer connection state
-	peerConnection.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
-		fmt.Printf("Peer Connection State has changed: %s\n", s.String())
-
-		if s == webrtc.PeerConnectionStateFailed {
-			fmt.Println("Peer Connection has gone to failed exiting")
-			os.Exit(0)
-		}
-
-		if s == webrtc.PeerConnectionStateClosed {
-			fmt.Println("Peer Connection has gone to closed exiting")
-			os.Exit(0)
-		}
-	})
-
-	// Create an answer
-	answer, err := peerConnection.CreateAnswer(nil)
-	if err != nil {
-		panic(err)
-	}
-
-	// Create a channel that is blocked until ICE Gathering is complete
-	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
-
-	// Set the LocalDescription and start our UDP listeners
-	if err = peerConnection.SetLocalDescription(answer); err != nil {
-		panic(err)
-	}
-
-	// Block until ICE Gathering is complete
-	<-gatherComplete
-
-	// Output the answer in base64 so we can paste it in the browser
-	fmt.Println(encode(peerConnection.LocalDescription()))
-
-	// Block forever
-	select {}
-}
-
-// Read from stdin until we get a newline
-func readUntilNewline() (in string) {
-	var err error
-
-	r := bufio.NewReader(os.Stdin)
-	for {
-		in, err = r.ReadString('\n')
-		if err != nil && !errors.Is(err, io.EOF) {
+		if err := PublishFiles(srv.registry, streamID, videoPath, audioPath); err != nil {
 			panic(err)
 		}
-
-		if in = strings.TrimSpace(in); len(in) > 0 {
-			break
-		}
 	}
 
-	fmt.Println("")
-	return
-}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/whip/", srv.HandleWHIP)
+	mux.HandleFunc("/whep/", srv.HandleWHEP)
+	mux.HandleFunc("/signaling/", srv.HandleSignaling)
 
-// JSON encode + base64 a SessionDescription
-func encode(obj *webrtc.SessionDescription) string {
-	b, err := json.Marshal(obj)
-	if err != nil {
-		panic(err)
+	addr := os.Getenv("HTTP_ADDR")
+	if addr == "" {
+		addr = ":8080"
 	}
 
-	return base64.StdEncoding.EncodeToString(b)
+	fmt.Printf("Listening on %s (WHIP: /whip/{streamID}, WHEP: /whep/{streamID})\n", addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
 }
 
-// Decode a base64 and unmarshal JSON into a SessionDescription
-func decode(in string, obj *webrtc.SessionDescription) {
-	b, err := base64.StdEncoding.DecodeString(in)
+// getTurnCredentials requests a Cloudflare TURN credential valid for ttl,
+// optionally scoped to customIdentifier so per-session credentials can be
+// issued and revoked independently.
+func getTurnCredentials(ttl time.Duration, customIdentifier string) (*TurnCredentials, error) {
+	reqBody := struct {
+		TTL              int    `json:"ttl"`
+		CustomIdentifier string `json:"customIdentifier,omitempty"`
+	}{
+		TTL:              int(ttl.Seconds()),
+		CustomIdentifier: customIdentifier,
+	}
+	b, err := json.Marshal(reqBody)
 	if err != nil {
-		panic(err)
-	}
-
-	if err = json.Unmarshal(b, obj); err != nil {
-		panic(err)
+		return nil, err
 	}
-}
 
-func getTurnCredentials() (*TurnCredentials, error) {
-	url := cloudflareTurnAPI
-	req, err := http.NewRequest("POST", url, nil)
+	req, err := http.NewRequest("POST", cloudflareTurnAPI, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Add("Authorization", "Bearer "+cloudflareApiToken)
 	req.Header.Add("Content-Type", "application/json")
-	req.Body = io.NopCloser(bytes.NewReader([]byte(`{"ttl": 86400}`)))
-
-
-
-	
+	req.Body = io.NopCloser(bytes.NewReader(b))
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -235,10 +92,6 @@ func getTurnCredentials() (*TurnCredentials, error) {
 	}
 	defer resp.Body.Close()
 
-	//body, _ := io.ReadAll(resp.Body)
-	//fmt.Printf("Response status: %s\n", resp.Status)
-	//fmt.Printf("Response body: %s\n", string(body))
-
 	if resp.StatusCode != http.StatusCreated {
 		return nil, fmt.Errorf("failed to get TURN credentials, status: %s", resp.Status)
 	}
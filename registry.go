@@ -0,0 +1,239 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// StreamRegistry maps stream IDs to the set of subscriber
+// PeerConnections currently pulling that stream, so a single publisher
+// can fan out to many viewers. It is safe for concurrent use.
+type StreamRegistry struct {
+	mu      sync.Mutex
+	streams map[string]*fanOut
+}
+
+// fanOut holds the local tracks a publisher writes into and the
+// subscribers currently receiving them.
+type fanOut struct {
+	video *webrtc.TrackLocalStaticRTP
+	audio *webrtc.TrackLocalStaticRTP
+
+	videoCodec webrtc.RTPCodecCapability
+	audioCodec webrtc.RTPCodecCapability
+
+	// active is the Publisher currently allowed to write into and tear
+	// down this fan-out. A reconnecting publisher that reuses these
+	// tracks (see Publish) replaces it, which demotes the previous
+	// Publisher: its writes are rejected and its eventual Close is a
+	// no-op, so a publisher whose PeerConnection hasn't failed yet but
+	// has already been superseded can't interleave RTP into the tracks
+	// or tear down the new publisher's stream.
+	active *Publisher
+
+	subscribers map[*webrtc.PeerConnection]struct{}
+}
+
+// NewStreamRegistry creates an empty StreamRegistry.
+func NewStreamRegistry() *StreamRegistry {
+	return &StreamRegistry{streams: map[string]*fanOut{}}
+}
+
+// Publisher writes incoming RTP for a single published stream into its
+// fan-out tracks.
+type Publisher struct {
+	streamID string
+	registry *StreamRegistry
+	fanOut   *fanOut
+}
+
+// Publish claims streamID for a new publisher, creating its video and
+// audio fan-out tracks using the negotiated codecs. If streamID is
+// already published with matching codecs, the existing fan-out tracks
+// are reused so a reconnecting publisher keeps the same subscribers; the
+// new Publisher becomes the fan-out's active one, demoting whatever
+// Publisher held it before (see fanOut.active) so a slow-to-disconnect
+// previous publisher can't keep writing into the stream it just lost.
+// Publishing to a stream that's already live with a different codec is
+// rejected, since subscribers already bound to the existing tracks would
+// start receiving RTP under a codec they never negotiated.
+func (r *StreamRegistry) Publish(streamID string, videoCodec, audioCodec webrtc.RTPCodecCapability) (*Publisher, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.streams[streamID]; ok {
+		if !codecsMatch(f.videoCodec, videoCodec) || !codecsMatch(f.audioCodec, audioCodec) {
+			return nil, fmt.Errorf("stream %q is already published with a different codec", streamID)
+		}
+		p := &Publisher{streamID: streamID, registry: r, fanOut: f}
+		f.active = p
+		return p, nil
+	}
+
+	video, err := webrtc.NewTrackLocalStaticRTP(videoCodec, "video", streamID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create video track: %w", err)
+	}
+
+	audio, err := webrtc.NewTrackLocalStaticRTP(audioCodec, "audio", streamID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audio track: %w", err)
+	}
+
+	f := &fanOut{
+		video:       video,
+		audio:       audio,
+		videoCodec:  videoCodec,
+		audioCodec:  audioCodec,
+		subscribers: map[*webrtc.PeerConnection]struct{}{},
+	}
+	p := &Publisher{streamID: streamID, registry: r, fanOut: f}
+	f.active = p
+	r.streams[streamID] = f
+
+	return p, nil
+}
+
+// codecsMatch reports whether two codec capabilities describe the same
+// wire format for fan-out purposes.
+func codecsMatch(a, b webrtc.RTPCodecCapability) bool {
+	return strings.EqualFold(a.MimeType, b.MimeType) && a.ClockRate == b.ClockRate
+}
+
+// errStalePublisher is returned by WriteVideo/WriteAudio once a
+// reconnecting publisher has taken over the stream, so the superseded
+// Publisher's caller (ForwardPublishedTrack) stops forwarding instead of
+// interleaving its RTP into tracks a newer publisher now owns.
+var errStalePublisher = fmt.Errorf("publisher has been superseded by a newer one for this stream")
+
+// WriteVideo forwards a video RTP packet to every current subscriber.
+func (p *Publisher) WriteVideo(pkt *rtp.Packet) error {
+	if !p.isActive() {
+		return errStalePublisher
+	}
+	return p.fanOut.video.WriteRTP(pkt)
+}
+
+// WriteAudio forwards an audio RTP packet to every current subscriber.
+func (p *Publisher) WriteAudio(pkt *rtp.Packet) error {
+	if !p.isActive() {
+		return errStalePublisher
+	}
+	return p.fanOut.audio.WriteRTP(pkt)
+}
+
+func (p *Publisher) isActive() bool {
+	p.registry.mu.Lock()
+	defer p.registry.mu.Unlock()
+	return p.fanOut.active == p
+}
+
+// Close unpublishes the stream, dropping its fan-out tracks and closing
+// every current subscriber's PeerConnection, since those tracks will
+// never receive media again. Subscribers are expected to reconnect once
+// a new publisher claims the stream. If p has already been superseded by
+// a reconnecting publisher (see fanOut.active), Close is a no-op: the
+// stream is still live under the newer Publisher and must not be torn
+// down just because the old one finally noticed its PeerConnection died.
+func (p *Publisher) Close() {
+	p.registry.mu.Lock()
+	f, ok := p.registry.streams[p.streamID]
+	if ok && f.active == p {
+		delete(p.registry.streams, p.streamID)
+	} else {
+		ok = false
+	}
+	p.registry.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	for pc := range f.subscribers {
+		pc.Close() //nolint:errcheck
+	}
+}
+
+// ForwardPublishedTrack relays every RTP packet pc receives into
+// publisher, routing by track kind. Both the WHIP ingest handler and the
+// websocket signaling publisher role use this to feed the registry.
+func ForwardPublishedTrack(pc *webrtc.PeerConnection, publisher *Publisher) {
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		for {
+			pkt, _, readErr := track.ReadRTP()
+			if readErr != nil {
+				return
+			}
+
+			var writeErr error
+			if track.Kind() == webrtc.RTPCodecTypeAudio {
+				writeErr = publisher.WriteAudio(pkt)
+			} else {
+				writeErr = publisher.WriteVideo(pkt)
+			}
+			if writeErr != nil {
+				return
+			}
+		}
+	})
+}
+
+// Subscribe adds streamID's fan-out tracks to pc, so pc starts receiving
+// that stream's media, and spawns an RTCP reader per added track.
+// Callers own pc's OnConnectionStateChange handler and must call
+// Unsubscribe once pc transitions to Failed or Closed.
+func (r *StreamRegistry) Subscribe(streamID string, pc *webrtc.PeerConnection) error {
+	r.mu.Lock()
+	f, ok := r.streams[streamID]
+	if ok {
+		f.subscribers[pc] = struct{}{}
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("stream %q is not published", streamID)
+	}
+
+	for _, track := range []*webrtc.TrackLocalStaticRTP{f.video, f.audio} {
+		sender, err := pc.AddTrack(track)
+		if err != nil {
+			r.Unsubscribe(streamID, pc)
+			return fmt.Errorf("failed to add %s track: %w", track.Kind(), err)
+		}
+		go readRTCP(sender)
+	}
+
+	return nil
+}
+
+// Unsubscribe removes pc from streamID's subscriber set. It's a no-op if
+// pc was never subscribed or the stream has since been unpublished.
+func (r *StreamRegistry) Unsubscribe(streamID string, pc *webrtc.PeerConnection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.streams[streamID]; ok {
+		delete(f.subscribers, pc)
+	}
+}
+
+// readRTCP drains RTCP packets for sender until its PeerConnection is torn
+// down, as pion requires the receive side of a sender to be read to avoid
+// blocking.
+func readRTCP(sender *webrtc.RTPSender) {
+	rtcpBuf := make([]byte, 1500)
+	for {
+		if _, _, err := sender.Read(rtcpBuf); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestPublishReconnectSupersedesPreviousPublisher(t *testing.T) {
+	registry := NewStreamRegistry()
+	videoCodec := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+	audioCodec := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}
+
+	a, err := registry.Publish("cam1", videoCodec, audioCodec)
+	if err != nil {
+		t.Fatalf("Publish (a): %v", err)
+	}
+
+	b, err := registry.Publish("cam1", videoCodec, audioCodec)
+	if err != nil {
+		t.Fatalf("Publish (b): %v", err)
+	}
+
+	if err := a.WriteVideo(&rtp.Packet{}); err != errStalePublisher {
+		t.Fatalf("superseded publisher a wrote successfully, want errStalePublisher, got %v", err)
+	}
+
+	if err := b.WriteVideo(&rtp.Packet{}); err != nil {
+		t.Fatalf("active publisher b failed to write: %v", err)
+	}
+
+	// a's PeerConnection finally noticing it's dead must not tear down
+	// the stream b is actively publishing.
+	a.Close()
+
+	if _, ok := registry.streams["cam1"]; !ok {
+		t.Fatal("Close on a superseded publisher unpublished the stream still owned by b")
+	}
+
+	if err := b.WriteVideo(&rtp.Packet{}); err != nil {
+		t.Fatalf("b should still be able to write after a.Close(): %v", err)
+	}
+
+	b.Close()
+	if _, ok := registry.streams["cam1"]; ok {
+		t.Fatal("Close on the active publisher should unpublish the stream")
+	}
+}
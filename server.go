@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/intervalpli"
+	"github.com/pion/webrtc/v4"
+)
+
+// Server holds the shared WebRTC configuration used to create a
+// PeerConnection for every WHIP/WHEP request, along with the set of
+// sessions currently in flight so they can be torn down later.
+type Server struct {
+	turnManager *TurnCredentialManager
+
+	mu       sync.Mutex
+	sessions map[string]*webrtc.PeerConnection
+
+	registry *StreamRegistry
+}
+
+// NewServer creates a Server that mints PeerConnections using ICE servers
+// from turnManager.
+func NewServer(turnManager *TurnCredentialManager) *Server {
+	return &Server{
+		turnManager: turnManager,
+		sessions:    map[string]*webrtc.PeerConnection{},
+		registry:    NewStreamRegistry(),
+	}
+}
+
+// iceConfig returns the webrtc.Configuration to use for a new
+// PeerConnection, built from the TURN credential currently in effect.
+func (s *Server) iceConfig() webrtc.Configuration {
+	return webrtc.Configuration{ICEServers: []webrtc.ICEServer{s.turnManager.Current()}}
+}
+
+// newAPI builds a webrtc.API around m with the server's standard
+// interceptor chain (the default set plus a PLI generator). Each
+// WHIP/WHEP request builds its own API from a MediaEngine populated for
+// that request's offer, since codecs are negotiated per-connection.
+func (s *Server) newAPI(m *webrtc.MediaEngine) (*webrtc.API, error) {
+	i := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+		return nil, fmt.Errorf("failed to register interceptors: %w", err)
+	}
+
+	intervalPliFactory, err := intervalpli.NewReceiverInterceptor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PLI interceptor: %w", err)
+	}
+	i.Add(intervalPliFactory)
+
+	return webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i)), nil
+}
+
+// addSession registers a PeerConnection under resourceID so it can be torn
+// down by a later DELETE request.
+func (s *Server) addSession(resourceID string, pc *webrtc.PeerConnection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[resourceID] = pc
+}
+
+// removeSession removes and returns the PeerConnection registered under
+// resourceID, if any.
+func (s *Server) removeSession(resourceID string) (*webrtc.PeerConnection, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pc, ok := s.sessions[resourceID]
+	if ok {
+		delete(s.sessions, resourceID)
+	}
+	return pc, ok
+}
+
+// randomID returns a short random hex string suitable for use as a
+// WHIP/WHEP resource ID suffix.
+func randomID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// signalMessage is the envelope exchanged over the /signaling websocket:
+// an "offer" or "answer" carries an SDP in Data, a "candidate" carries a
+// JSON-encoded webrtc.ICECandidateInit.
+type signalMessage struct {
+	Event string `json:"event"`
+	Data  string `json:"data"`
+}
+
+const (
+	eventOffer     = "offer"
+	eventAnswer    = "answer"
+	eventCandidate = "candidate"
+)
+
+// HandleSignaling upgrades to a websocket and runs trickle-ICE signaling
+// for one PeerConnection in the room named by the last path segment
+// (e.g. /signaling/my-room). A "?role=subscribe" query parameter joins
+// the room as a viewer; the default "publish" role feeds the room into
+// the StreamRegistry under the room name.
+//
+// Unlike WHIP/WHEP, which wait for ICE gathering to finish before
+// exchanging SDP, this handler answers the offer immediately and then
+// streams ICECandidateInit messages both directions as they trickle in,
+// which avoids the gathering delay WHIP/WHEP pay behind Cloudflare's
+// TURN relay.
+func (s *Server) HandleSignaling(w http.ResponseWriter, r *http.Request) {
+	room := strings.TrimPrefix(r.URL.Path, "/signaling/")
+	if room == "" {
+		http.Error(w, "missing room", http.StatusBadRequest)
+		return
+	}
+	subscribe := r.URL.Query().Get("role") == "subscribe"
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		fmt.Printf("signaling: failed to upgrade websocket: %v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	var msg signalMessage
+	if err := conn.ReadJSON(&msg); err != nil || msg.Event != eventOffer {
+		fmt.Printf("signaling: expected an offer first in room %s (err: %v)\n", room, err)
+		return
+	}
+
+	m, videoCodec, audioCodec, err := NegotiateOffer(msg.Data)
+	if err != nil {
+		fmt.Printf("signaling: failed to negotiate codecs for room %s: %v\n", room, err)
+		return
+	}
+
+	api, err := s.newAPI(m)
+	if err != nil {
+		fmt.Printf("signaling: %v\n", err)
+		return
+	}
+
+	peerConnection, err := api.NewPeerConnection(s.iceConfig())
+	if err != nil {
+		fmt.Printf("signaling: failed to create PeerConnection for room %s: %v\n", room, err)
+		return
+	}
+	defer peerConnection.Close() //nolint:errcheck
+
+	resourceID := room + "-" + randomID()
+	s.addSession(resourceID, peerConnection)
+	defer s.removeSession(resourceID)
+
+	var writeMu sync.Mutex
+	writeJSON := func(v signalMessage) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := conn.WriteJSON(v); err != nil {
+			fmt.Printf("signaling: failed to write to websocket: %v\n", err)
+		}
+	}
+
+	peerConnection.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c == nil {
+			return
+		}
+
+		candidateJSON, err := json.Marshal(c.ToJSON())
+		if err != nil {
+			fmt.Printf("signaling: failed to marshal ICE candidate: %v\n", err)
+			return
+		}
+
+		writeJSON(signalMessage{Event: eventCandidate, Data: string(candidateJSON)})
+	})
+
+	var publisher *Publisher
+	if subscribe {
+		if err := s.registry.Subscribe(room, peerConnection); err != nil {
+			fmt.Printf("signaling: %v\n", err)
+			return
+		}
+	} else {
+		publisher, err = s.registry.Publish(room, videoCodec, audioCodec)
+		if err != nil {
+			fmt.Printf("signaling: failed to publish room %s: %v\n", room, err)
+			return
+		}
+		ForwardPublishedTrack(peerConnection, publisher)
+	}
+
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		fmt.Printf("signaling room %s: %s\n", room, state.String())
+
+		if state != webrtc.PeerConnectionStateFailed && state != webrtc.PeerConnectionStateClosed {
+			return
+		}
+
+		if publisher != nil {
+			publisher.Close()
+		} else {
+			s.registry.Unsubscribe(room, peerConnection)
+		}
+	})
+
+	if err := peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: msg.Data}); err != nil {
+		fmt.Printf("signaling: failed to set remote description for room %s: %v\n", room, err)
+		return
+	}
+
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		fmt.Printf("signaling: failed to create answer for room %s: %v\n", room, err)
+		return
+	}
+
+	if err := peerConnection.SetLocalDescription(answer); err != nil {
+		fmt.Printf("signaling: failed to set local description for room %s: %v\n", room, err)
+		return
+	}
+
+	writeJSON(signalMessage{Event: eventAnswer, Data: answer.SDP})
+
+	for {
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		if msg.Event != eventCandidate {
+			fmt.Printf("signaling: unexpected event %q in room %s\n", msg.Event, room)
+			continue
+		}
+
+		var candidate webrtc.ICECandidateInit
+		if err := json.Unmarshal([]byte(msg.Data), &candidate); err != nil {
+			fmt.Printf("signaling: failed to unmarshal ICE candidate: %v\n", err)
+			continue
+		}
+
+		if err := peerConnection.AddICECandidate(candidate); err != nil {
+			fmt.Printf("signaling: failed to add ICE candidate for room %s: %v\n", room, err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// turnCredentialTTL is the lifetime requested for every TURN credential.
+const turnCredentialTTL = 86400 * time.Second
+
+// refreshFraction is how far into a credential's TTL the manager
+// refreshes it, leaving headroom before Cloudflare would reject it.
+const refreshFraction = 0.8
+
+// maxRefreshBackoff caps the exponential backoff between failed refresh
+// attempts.
+const maxRefreshBackoff = time.Minute
+
+// Clock abstracts time so TurnCredentialManager's refresh loop can be
+// driven deterministically in tests instead of sleeping for real.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// turnFetcher fetches a TURN credential; getTurnCredentials in
+// production, a fake in tests.
+type turnFetcher func(ttl time.Duration, customIdentifier string) (*TurnCredentials, error)
+
+// TurnCredentialManager fetches a Cloudflare TURN credential once and
+// keeps it fresh in the background, so every PeerConnection created
+// during the process lifetime can reuse Current() instead of paying for
+// a round-trip to Cloudflare on every connection.
+type TurnCredentialManager struct {
+	customIdentifier string
+	clock            Clock
+	fetch            turnFetcher
+
+	mu      sync.RWMutex
+	current webrtc.ICEServer
+
+	stop chan struct{}
+}
+
+// NewTurnCredentialManager fetches an initial credential scoped to
+// customIdentifier (pass "" for none) and starts a background goroutine
+// that refreshes it at ~80% of its TTL. If clock is nil, real wall-clock
+// time is used.
+func NewTurnCredentialManager(customIdentifier string, clock Clock) (*TurnCredentialManager, error) {
+	return newTurnCredentialManager(customIdentifier, clock, getTurnCredentials)
+}
+
+func newTurnCredentialManager(customIdentifier string, clock Clock, fetch turnFetcher) (*TurnCredentialManager, error) {
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	m := &TurnCredentialManager{
+		customIdentifier: customIdentifier,
+		clock:            clock,
+		fetch:            fetch,
+		stop:             make(chan struct{}),
+	}
+
+	credentials, err := fetch(turnCredentialTTL, customIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	m.set(credentials)
+
+	go m.refreshLoop()
+
+	return m, nil
+}
+
+// Current returns the most recently fetched ICE server. It is safe to
+// call from many goroutines creating PeerConnections concurrently.
+func (m *TurnCredentialManager) Current() webrtc.ICEServer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.current
+}
+
+// Stop ends the background refresh loop. The last fetched credential
+// remains available from Current.
+func (m *TurnCredentialManager) Stop() {
+	close(m.stop)
+}
+
+func (m *TurnCredentialManager) set(c *TurnCredentials) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.current = webrtc.ICEServer{
+		URLs:       c.ICECredentials.URLs,
+		Username:   c.ICECredentials.Username,
+		Credential: c.ICECredentials.Credential,
+	}
+}
+
+func (m *TurnCredentialManager) refreshLoop() {
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-m.clock.After(time.Duration(float64(turnCredentialTTL) * refreshFraction)):
+		}
+
+		if !m.refreshWithRetry() {
+			return
+		}
+	}
+}
+
+// refreshWithRetry fetches a new credential, retrying with exponential
+// backoff (capped at maxRefreshBackoff) until it succeeds or Stop is
+// called. It reports whether it succeeded.
+func (m *TurnCredentialManager) refreshWithRetry() bool {
+	backoff := time.Second
+	for {
+		credentials, err := m.fetch(turnCredentialTTL, m.customIdentifier)
+		if err == nil {
+			m.set(credentials)
+			return true
+		}
+
+		fmt.Printf("failed to refresh TURN credentials, retrying in %s: %v\n", backoff, err)
+
+		select {
+		case <-m.stop:
+			return false
+		case <-m.clock.After(backoff):
+		}
+
+		if backoff *= 2; backoff > maxRefreshBackoff {
+			backoff = maxRefreshBackoff
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose After calls block until the test explicitly
+// fires them, so refresh/backoff timing can be driven deterministically
+// instead of sleeping for real.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+
+	waiting chan fakeWait
+}
+
+type fakeWait struct {
+	d  time.Duration
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{waiting: make(chan fakeWait, 16)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.waiting <- fakeWait{d: d, ch: ch}
+	return ch
+}
+
+// fire waits for the next After call, fails the test if it wasn't called
+// with want, and releases it.
+func (c *fakeClock) fire(t *testing.T, want time.Duration) {
+	t.Helper()
+	select {
+	case w := <-c.waiting:
+		if w.d != want {
+			t.Fatalf("clock.After called with %s, want %s", w.d, want)
+		}
+		c.mu.Lock()
+		c.now = c.now.Add(w.d)
+		c.mu.Unlock()
+		w.ch <- c.now
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for clock.After to be called")
+	}
+}
+
+func TestRefreshLoopRefreshesAt80PercentTTL(t *testing.T) {
+	clock := newFakeClock()
+	fetched := make(chan struct{}, 1)
+	fetch := func(time.Duration, string) (*TurnCredentials, error) {
+		fetched <- struct{}{}
+		return &TurnCredentials{}, nil
+	}
+
+	m, err := newTurnCredentialManager("", clock, fetch)
+	if err != nil {
+		t.Fatalf("newTurnCredentialManager: %v", err)
+	}
+	defer m.Stop()
+
+	select {
+	case <-fetched:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for initial fetch")
+	}
+
+	clock.fire(t, time.Duration(float64(turnCredentialTTL)*refreshFraction))
+
+	select {
+	case <-fetched:
+	case <-time.After(time.Second):
+		t.Fatal("expected a refresh once 80% of the TTL elapsed")
+	}
+}
+
+func TestRefreshWithRetryBacksOffAndCaps(t *testing.T) {
+	clock := newFakeClock()
+	failuresLeft := 7
+	fetch := func(time.Duration, string) (*TurnCredentials, error) {
+		if failuresLeft > 0 {
+			failuresLeft--
+			return nil, errors.New("cloudflare unavailable")
+		}
+		return &TurnCredentials{}, nil
+	}
+
+	m := &TurnCredentialManager{clock: clock, fetch: fetch, stop: make(chan struct{})}
+
+	done := make(chan bool, 1)
+	go func() { done <- m.refreshWithRetry() }()
+
+	for _, want := range []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		16 * time.Second,
+		32 * time.Second,
+		maxRefreshBackoff, // 64s would exceed the cap, so backoff clamps to maxRefreshBackoff
+	} {
+		clock.fire(t, want)
+	}
+
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatal("refreshWithRetry reported failure")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for refreshWithRetry to succeed")
+	}
+}
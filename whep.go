@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// HandleWHEP subscribes a viewer to a previously published stream and
+// answers with the SDP needed to start receiving media, per the WHEP
+// draft (draft-murillo-whep). A POST to /whep/my-stream subscribes to
+// the stream published as "my-stream".
+func (s *Server) HandleWHEP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		s.handleWHEPDelete(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	streamID := strings.TrimPrefix(r.URL.Path, "/whep/")
+	if streamID == "" {
+		http.Error(w, "missing stream ID", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read offer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, _, _, err := NegotiateOffer(string(body))
+	if err != nil {
+		http.Error(w, "failed to negotiate codecs: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	api, err := s.newAPI(m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	peerConnection, err := api.NewPeerConnection(s.iceConfig())
+	if err != nil {
+		http.Error(w, "failed to create PeerConnection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.registry.Subscribe(streamID, peerConnection); err != nil {
+		peerConnection.Close() //nolint:errcheck
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fmt.Printf("WHEP subscriber joined stream %s\n", streamID)
+
+	resourceID := streamID + "-" + randomID()
+	s.addSession(resourceID, peerConnection)
+
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		fmt.Printf("WHEP subscriber %s: %s\n", streamID, state.String())
+
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			s.registry.Unsubscribe(streamID, peerConnection)
+			s.removeSession(resourceID)
+		}
+	})
+
+	answer, err := s.negotiate(peerConnection, webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(body)})
+	if err != nil {
+		s.registry.Unsubscribe(streamID, peerConnection)
+		s.removeSession(resourceID)
+		peerConnection.Close() //nolint:errcheck
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/whep/"+resourceID)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(answer.SDP)) //nolint:errcheck
+}
+
+func (s *Server) handleWHEPDelete(w http.ResponseWriter, r *http.Request) {
+	resourceID := strings.TrimPrefix(r.URL.Path, "/whep/")
+	peerConnection, ok := s.removeSession(resourceID)
+	if !ok {
+		http.Error(w, "unknown resource", http.StatusNotFound)
+		return
+	}
+
+	if err := peerConnection.Close(); err != nil {
+		http.Error(w, "failed to close PeerConnection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// HandleWHIP ingests a publisher's SDP offer and answers with the SDP
+// needed to start sending media, per the WHIP draft
+// (draft-ietf-wish-whip). The stream is published under the last path
+// segment, e.g. a POST to /whip/my-stream publishes as "my-stream".
+func (s *Server) HandleWHIP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		s.handleWHIPDelete(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	streamID := strings.TrimPrefix(r.URL.Path, "/whip/")
+	if streamID == "" {
+		http.Error(w, "missing stream ID", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read offer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, videoCodec, audioCodec, err := NegotiateOffer(string(body))
+	if err != nil {
+		http.Error(w, "failed to negotiate codecs: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	api, err := s.newAPI(m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	peerConnection, err := api.NewPeerConnection(s.iceConfig())
+	if err != nil {
+		http.Error(w, "failed to create PeerConnection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	publisher, err := s.registry.Publish(streamID, videoCodec, audioCodec)
+	if err != nil {
+		peerConnection.Close() //nolint:errcheck
+		http.Error(w, "failed to publish stream: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ForwardPublishedTrack(peerConnection, publisher)
+
+	resourceID := streamID + "-" + randomID()
+	s.addSession(resourceID, peerConnection)
+
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		fmt.Printf("WHIP publisher %s: %s\n", streamID, state.String())
+
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			publisher.Close()
+			s.removeSession(resourceID)
+		}
+	})
+
+	answer, err := s.negotiate(peerConnection, webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(body)})
+	if err != nil {
+		s.removeSession(resourceID)
+		peerConnection.Close() //nolint:errcheck
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/whip/"+resourceID)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(answer.SDP)) //nolint:errcheck
+}
+
+func (s *Server) handleWHIPDelete(w http.ResponseWriter, r *http.Request) {
+	resourceID := strings.TrimPrefix(r.URL.Path, "/whip/")
+	peerConnection, ok := s.removeSession(resourceID)
+	if !ok {
+		http.Error(w, "unknown resource", http.StatusNotFound)
+		return
+	}
+
+	if err := peerConnection.Close(); err != nil {
+		http.Error(w, "failed to close PeerConnection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// negotiate sets offer as the remote description, creates and sets a
+// local answer, waits for ICE gathering to finish, and returns the
+// fully gathered local SessionDescription.
+func (s *Server) negotiate(peerConnection *webrtc.PeerConnection, offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	if err := peerConnection.SetRemoteDescription(offer); err != nil {
+		return nil, fmt.Errorf("failed to set remote description: %w", err)
+	}
+
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create answer: %w", err)
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
+
+	if err := peerConnection.SetLocalDescription(answer); err != nil {
+		return nil, fmt.Errorf("failed to set local description: %w", err)
+	}
+
+	<-gatherComplete
+
+	return peerConnection.LocalDescription(), nil
+}